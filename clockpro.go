@@ -20,8 +20,9 @@ import (
 )
 
 type cacheEntry struct {
-	ref bool
-	val interface{}
+	ref  bool
+	val  interface{}
+	cost int64
 }
 
 type pageType int
@@ -49,6 +50,7 @@ func (p pageType) String() string {
 type metaEntry struct {
 	ptype pageType
 	key   string
+	cost  int64
 }
 
 // TODO(dgryski): combine data and metaKeys map
@@ -56,43 +58,202 @@ type metaEntry struct {
 // TODO(dgryski): container/list -> container/ring
 
 type Cache struct {
-	mem_max  int
-	mem_cold int
-	data     map[string]*cacheEntry
-	meta     *list.List
-	metaKeys map[string]*list.Element
+	mem_max      int64
+	mem_cold     int64
+	mem_test_max int64
+	costFn       func(key string, val interface{}) int64
+	onEvict      func(key string, val interface{})
+	data         map[string]*cacheEntry
+	meta         *list.List
+	metaKeys     map[string]*list.Element
 
 	hand_pos_hot  *list.Element
 	hand_pos_cold *list.Element
 	hand_pos_test *list.Element
 
-	hand_idx_hot  int
-	hand_idx_cold int
-	hand_idx_test int
-
 	count_hot  int
 	count_cold int
 	count_test int
+
+	cost_hot  int64
+	cost_cold int64
+
+	stat_hits           int64
+	stat_misses         int64
+	stat_insertions     int64
+	stat_evictions      int64
+	stat_test_hits      int64
+	stat_hot_promotions int64
+	stat_hot_demotions  int64
+}
+
+// Stats reports cumulative counters describing a Cache's behavior, useful
+// for verifying the adaptive mem_cold target is tracking a workload well
+// or for comparing against alternative eviction policies.
+type Stats struct {
+	Hits          int64
+	Misses        int64
+	Insertions    int64
+	Evictions     int64
+	TestHits      int64
+	HotPromotions int64
+	HotDemotions  int64
+
+	CountHot  int
+	CountCold int
+	CountTest int
+	MemCold   int64
+}
+
+// Stats returns a snapshot of the cache's cumulative counters and current
+// residency.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:          c.stat_hits,
+		Misses:        c.stat_misses,
+		Insertions:    c.stat_insertions,
+		Evictions:     c.stat_evictions,
+		TestHits:      c.stat_test_hits,
+		HotPromotions: c.stat_hot_promotions,
+		HotDemotions:  c.stat_hot_demotions,
+
+		CountHot:  c.count_hot,
+		CountCold: c.count_cold,
+		CountTest: c.count_test,
+		MemCold:   c.mem_cold,
+	}
 }
 
 func New(size int) *Cache {
 	return &Cache{
-		mem_max:  size,
-		mem_cold: size,
-		data:     make(map[string]*cacheEntry),
-		metaKeys: make(map[string]*list.Element),
-		meta:     list.New(),
+		mem_max:      int64(size),
+		mem_cold:     int64(size),
+		mem_test_max: int64(size),
+		data:         make(map[string]*cacheEntry),
+		metaKeys:     make(map[string]*list.Element),
+		meta:         list.New(),
+	}
+
+}
+
+// defaultGhostCap is the test (ghost) list size NewWithCost uses until the
+// caller overrides it with SetGhostCap. The ghost list tracks evicted
+// *keys*, not their values, so its size needs its own entry-count bound
+// independent of the byte/cost budget used for resident entries.
+const defaultGhostCap = 10000
+
+// NewWithCost creates a Cache whose capacity is measured in the units
+// returned by costFn instead of a fixed number of entries. costFn is
+// called with each key/value pair being inserted via Set to determine how
+// much of maxCost it consumes; use SetWithCost to supply an explicit cost
+// instead of computing one from the value.
+//
+// The test (ghost) list that remembers recently-evicted keys is sized in
+// entries, not cost units, since it's what lets CLOCK-Pro distinguish a
+// cold page from a hot one regardless of how large either is. It defaults
+// to defaultGhostCap entries; call SetGhostCap to tune it for workloads
+// with many more (or fewer) distinct keys than that.
+func NewWithCost(maxCost int64, costFn func(key string, val interface{}) int64) *Cache {
+	return &Cache{
+		mem_max:      maxCost,
+		mem_cold:     maxCost,
+		mem_test_max: defaultGhostCap,
+		costFn:       costFn,
+		data:         make(map[string]*cacheEntry),
+		metaKeys:     make(map[string]*list.Element),
+		meta:         list.New(),
+	}
+}
+
+// SetGhostCap sets the maximum number of entries kept on the test (ghost)
+// list, independent of the cost-based mem_max used for resident hot/cold
+// entries. It's primarily useful on a Cache created with NewWithCost,
+// where the ghost list's natural bound (mem_max) is expressed in the
+// wrong units.
+func (c *Cache) SetGhostCap(n int) {
+	c.mem_test_max = int64(n)
+}
+
+// OnEvict registers fn to be called whenever a resident entry is evicted
+// from the cache, i.e. demoted from cold to the test (ghost) list, or
+// dropped entirely from the test list once it has aged out. fn is called
+// with the value the key held while resident, or nil if it had already
+// been evicted to the test list. Only one eviction callback can be
+// registered at a time; calling OnEvict again replaces the previous one.
+func (c *Cache) OnEvict(fn func(key string, value interface{})) {
+	c.onEvict = fn
+}
+
+// Remove deletes key from the cache, if present, and reports whether it
+// was found. It updates the hot/cold/test bookkeeping exactly as an
+// internal demotion would and, if an eviction callback is registered,
+// invokes it with the value key held while resident, or with nil if key
+// was only on the test (ghost) list.
+func (c *Cache) Remove(key string) bool {
+
+	elt, ok := c.metaKeys[key]
+	if !ok {
+		return false
+	}
+
+	v := c.data[key]
+	meta := elt.Value.(*metaEntry)
+
+	switch meta.ptype {
+	case ptHot:
+		c.count_hot--
+		c.cost_hot -= v.cost
+	case ptCold:
+		c.count_cold--
+		c.cost_cold -= v.cost
+	case ptTest:
+		c.count_test--
+	}
+
+	if c.onEvict != nil {
+		// v is nil for a ptTest (ghost) entry, same as the onEvict call
+		// hand_test makes when a ghost entry ages out naturally.
+		var val interface{}
+		if v != nil {
+			val = v.val
+		}
+		c.onEvict(key, val)
 	}
 
+	delete(c.data, key)
+	c.meta_del(key)
+
+	c.VerifyIdxs()
+
+	return true
+}
+
+// Purge removes every entry from the cache, invoking the eviction
+// callback (if any) for each key as Remove does (nil for test-list keys),
+// and resets the entries, hands and mem_cold to the state of a freshly
+// constructed Cache. The cumulative counters reported by Stats are not
+// reset.
+func (c *Cache) Purge() {
+
+	for key := range c.metaKeys {
+		c.Remove(key)
+	}
+
+	// meta_del already reset the hands to nil once the last entry was
+	// removed; mem_cold is the only other piece of entry state Remove
+	// doesn't restore on its own.
+	c.mem_cold = c.mem_max
 }
 
 func (c *Cache) Get(key string) interface{} {
 
 	if v, ok := c.data[key]; ok && v != nil {
 		v.ref = true
+		c.stat_hits++
 		return v.val
 	}
 
+	c.stat_misses++
 	return nil
 }
 
@@ -109,33 +270,74 @@ func TRACE(what string) func() {
 }
 
 func (c *Cache) Set(key string, value interface{}) {
+	cost := int64(1)
+	if c.costFn != nil {
+		cost = c.costFn(key, value)
+	}
+	c.set(key, value, cost)
+}
+
+// SetWithCost stores value under key with an explicit cost, bypassing the
+// costFn passed to NewWithCost. On a Cache created with New, the cost is
+// counted against the size passed to New in place of the default of 1.
+func (c *Cache) SetWithCost(key string, value interface{}, cost int64) {
+	c.set(key, value, cost)
+}
+
+func (c *Cache) set(key string, value interface{}, cost int64) {
 	//	c.FullDump()
 	defer TRACE("set")()
 
 	if v, ok := c.data[key]; ok {
 		if v == nil {
-			if c.mem_cold < c.mem_max {
-				c.mem_cold++
+			if c.mem_cold+cost <= c.mem_max {
+				c.mem_cold += cost
+			} else {
+				c.mem_cold = c.mem_max
 			}
 			c.meta_del(key)
-			c.data[key] = &cacheEntry{ref: false, val: value}
+			c.data[key] = &cacheEntry{ref: false, val: value, cost: cost}
 			c.count_test--
-			c.meta_add(ptHot, key)
+			c.meta_add(ptHot, key, cost)
 			c.count_hot++
+			c.cost_hot += cost
+			c.stat_test_hits++
 		} else {
+			c.adjustCost(key, v, cost)
 			v.val = value
 			v.ref = true
 		}
 	} else {
-		c.data[key] = &cacheEntry{ref: false, val: value}
-		c.meta_add(ptCold, key)
+		c.data[key] = &cacheEntry{ref: false, val: value, cost: cost}
+		c.meta_add(ptCold, key, cost)
 		c.count_cold++
+		c.cost_cold += cost
+		c.stat_insertions++
 	}
 
 	c.VerifyIdxs()
 }
 
-func (c *Cache) meta_add(ptype pageType, key string) {
+// adjustCost updates the hot/cold cost totals to reflect entry's cost
+// changing to newCost, e.g. when Set overwrites an already-resident key
+// with a value of a different size.
+func (c *Cache) adjustCost(key string, entry *cacheEntry, newCost int64) {
+	delta := newCost - entry.cost
+	entry.cost = newCost
+
+	if elt, ok := c.metaKeys[key]; ok {
+		m := elt.Value.(*metaEntry)
+		m.cost = newCost
+		switch m.ptype {
+		case ptHot:
+			c.cost_hot += delta
+		case ptCold:
+			c.cost_cold += delta
+		}
+	}
+}
+
+func (c *Cache) meta_add(ptype pageType, key string, cost int64) {
 
 	defer TRACE("meta_add")()
 
@@ -144,6 +346,7 @@ func (c *Cache) meta_add(ptype pageType, key string) {
 	mentry := &metaEntry{
 		ptype: ptype,
 		key:   key,
+		cost:  cost,
 	}
 
 	if c.hand_pos_hot == nil {
@@ -153,49 +356,23 @@ func (c *Cache) meta_add(ptype pageType, key string) {
 		c.hand_pos_hot = elt
 		c.hand_pos_cold = elt
 		c.hand_pos_test = elt
-	} else {
-		c.VerifyIdxs()
-		c.metaKeys[key] = c.meta.InsertBefore(mentry, c.hand_pos_hot)
-
-		if c.hand_idx_cold >= c.hand_idx_hot {
-			c.hand_pos_cold = c.hand_pos_cold.Prev()
-		}
-
-		if c.hand_idx_test >= c.hand_idx_hot {
-			c.hand_pos_test = c.hand_pos_test.Prev()
-		}
-
-		c.hand_pos_hot = c.hand_pos_hot.Prev()
+		return
 	}
 
-	if c.hand_idx_cold > c.hand_idx_hot {
-		c.hand_idx_cold += 1
-		c.hand_pos_cold = c.hand_pos_cold.Next()
+	// New pages are always threaded in just behind the hot hand. hot and
+	// test keep pointing at the same element they did before the insert
+	// (container/list pointers are unaffected by inserting elsewhere), but
+	// if the cold hand was sitting on the same element as the hot hand, it
+	// needs to pick up the freshly-inserted page instead of being left
+	// behind it.
+	coldAtHot := c.hand_pos_cold == c.hand_pos_hot
 
-		if c.hand_pos_cold == nil {
-			c.hand_idx_cold = 0
-			c.hand_pos_cold = c.meta.Front()
-		}
-	}
-
-	c.VerifyIdxs()
+	elt := c.meta.InsertBefore(mentry, c.hand_pos_hot)
+	c.metaKeys[key] = elt
 
-	if c.hand_idx_test >= c.hand_idx_hot {
-		c.hand_idx_test += 1
-		c.hand_pos_test = c.hand_pos_test.Next()
-		if c.hand_pos_test == nil {
-			c.hand_idx_test = 0
-			c.hand_pos_test = c.meta.Front()
-		}
-	}
-	c.hand_idx_hot += 1
-	c.hand_pos_hot = c.hand_pos_hot.Next()
-	if c.hand_pos_hot == nil {
-		c.hand_idx_hot = 0
-		c.hand_pos_hot = c.meta.Front()
+	if coldAtHot {
+		c.hand_pos_cold = elt
 	}
-
-	c.VerifyIdxs()
 }
 
 func (c *Cache) meta_del(key string) {
@@ -210,19 +387,6 @@ func (c *Cache) meta_del(key string) {
 
 	delete(c.metaKeys, key)
 
-	c.VerifyIdxs()
-
-	var idx int
-
-	// FIXME(dgryski): get rid of this O(n) loop
-
-	for e := c.meta.Front(); e != nil; e = e.Next() {
-		if e == elt {
-			break
-		}
-		idx++
-	}
-
 	if elt == c.hand_pos_hot {
 		c.hand_pos_hot = c.hand_pos_hot.Prev()
 		if c.hand_pos_hot == nil {
@@ -246,38 +410,22 @@ func (c *Cache) meta_del(key string) {
 
 	c.meta.Remove(elt)
 
-	max_pos := c.meta.Len() - 1
-
-	if c.hand_idx_hot >= idx {
-		c.hand_idx_hot--
-		if c.hand_idx_hot < 0 {
-			c.hand_idx_hot = max_pos
-		}
-	}
-
-	if c.hand_idx_cold >= idx {
-		c.hand_idx_cold--
-		if c.hand_idx_cold < 0 {
-			c.hand_idx_cold = max_pos
-		}
-	}
-
-	if c.hand_idx_test >= idx {
-		c.hand_idx_test--
-		if c.hand_idx_test < 0 {
-			c.hand_idx_test = max_pos
-		}
+	if c.meta.Len() == 0 {
+		// No elements left for any hand to retreat to: reset to the same
+		// zero state New starts in, so the next meta_add takes the
+		// "first element" path instead of dereferencing a hand pointing
+		// at the element we just removed.
+		c.hand_pos_hot = nil
+		c.hand_pos_cold = nil
+		c.hand_pos_test = nil
 	}
-
-	c.VerifyIdxs()
-
 }
 
 func (c *Cache) evict() {
 
 	defer TRACE("evict")()
 
-	for c.mem_max <= c.count_hot+c.count_cold {
+	for c.mem_max <= c.cost_hot+c.cost_cold {
 		c.hand_cold()
 	}
 }
@@ -296,25 +444,35 @@ func (c *Cache) hand_cold() {
 			data.ref = false
 			c.count_cold--
 			c.count_hot++
+			c.cost_cold -= data.cost
+			c.cost_hot += data.cost
+			c.stat_hot_promotions++
 		} else {
 			meta.ptype = ptTest
+			if c.onEvict != nil {
+				c.onEvict(meta.key, data.val)
+			}
 			c.data[meta.key] = nil
 			c.count_cold--
 			c.count_test++
-			for c.mem_max < c.count_test {
+			c.cost_cold -= data.cost
+			c.stat_evictions++
+			// The ghost (test) list remembers evicted keys, not their
+			// values, so it's bounded by entry count (mem_test_max)
+			// rather than mem_max, which is in cost units once costFn
+			// is set.
+			for c.mem_test_max < int64(c.count_test) {
 				c.hand_test()
 			}
 		}
 	}
 
-	c.hand_idx_cold++
 	c.hand_pos_cold = c.hand_pos_cold.Next()
 	if c.hand_pos_cold == nil {
 		c.hand_pos_cold = c.meta.Front()
-		c.hand_idx_cold = 0
 	}
 
-	for c.mem_max-c.mem_cold < c.count_hot {
+	for c.mem_max-c.mem_cold < c.cost_hot {
 		c.hand_hot()
 	}
 }
@@ -338,14 +496,15 @@ func (c *Cache) hand_hot() {
 			meta.ptype = ptCold
 			c.count_hot--
 			c.count_cold++
+			c.cost_hot -= data.cost
+			c.cost_cold += data.cost
+			c.stat_hot_demotions++
 		}
 	}
 
-	c.hand_idx_hot++
 	c.hand_pos_hot = c.hand_pos_hot.Next()
 	if c.hand_pos_hot == nil {
 		c.hand_pos_hot = c.meta.Front()
-		c.hand_idx_hot = 0
 	}
 }
 
@@ -361,29 +520,27 @@ func (c *Cache) hand_test() {
 
 	if meta.ptype == ptTest {
 
-		delete(c.data, meta.key)
-
-		prev := c.hand_pos_test.Prev()
-		pidx := c.hand_idx_test - 1
-		if prev == nil {
-			prev = c.meta.Back()
-			pidx = c.meta.Len()
+		if c.onEvict != nil {
+			c.onEvict(meta.key, nil)
 		}
+
+		delete(c.data, meta.key)
+		// meta_del retreats hand_pos_test to the element preceding this
+		// one (wrapping to Back() if it was Front()) since it still
+		// equals c.hand_pos_test here.
 		c.meta_del(meta.key)
-		c.hand_pos_test = prev
-		c.hand_idx_test = pidx
 
 		c.count_test--
-		if c.mem_cold > 1 {
-			c.mem_cold--
+		if c.mem_cold-meta.cost > 1 {
+			c.mem_cold -= meta.cost
+		} else {
+			c.mem_cold = 1
 		}
 	}
 
-	c.hand_idx_test++
 	c.hand_pos_test = c.hand_pos_test.Next()
 	if c.hand_pos_test == nil {
 		c.hand_pos_test = c.meta.Front()
-		c.hand_idx_test = 0
 	}
 }
 
@@ -460,7 +617,6 @@ func (c *Cache) FullDump() {
 	*/
 
 	fmt.Println("-list-")
-	var idx int
 	for elt := c.meta.Front(); elt != nil; elt = elt.Next() {
 		m := elt.Value.(*metaEntry)
 
@@ -474,21 +630,15 @@ func (c *Cache) FullDump() {
 			fmt.Println("TEST pos")
 		}
 
-		if idx == c.hand_idx_hot {
-			fmt.Println("HOT idx")
-		}
-		if idx == c.hand_idx_cold {
-			fmt.Println("COLD idx")
-		}
-		if idx == c.hand_idx_test {
-			fmt.Println("TEST idx")
-		}
-
 		fmt.Printf("%+v\n", m)
-		idx++
 	}
 }
 
+// VerifyIdxs is a debug-only structural sanity check: it confirms each
+// hand still points at an element that is actually in the meta list, and
+// that the ptype of every element agrees with count_hot/count_cold/
+// count_test. It no longer checks hand positions against a parallel index,
+// since the cache doesn't keep one.
 func (c *Cache) VerifyIdxs() {
 
 	if !DEBUG {
@@ -499,26 +649,36 @@ func (c *Cache) VerifyIdxs() {
 		return
 	}
 
-	hotidx := -1
-	coldidx := -1
-	testidx := -1
+	var hotFound, coldFound, testFound bool
+	var nHot, nCold, nTest int
 
-	idx := 0
 	for e := c.meta.Front(); e != nil; e = e.Next() {
 		if e == c.hand_pos_hot {
-			hotidx = idx
+			hotFound = true
 		}
 		if e == c.hand_pos_cold {
-			coldidx = idx
+			coldFound = true
 		}
 		if e == c.hand_pos_test {
-			testidx = idx
+			testFound = true
 		}
-		idx++
+
+		switch e.Value.(*metaEntry).ptype {
+		case ptHot:
+			nHot++
+		case ptCold:
+			nCold++
+		case ptTest:
+			nTest++
+		}
+	}
+
+	if !hotFound || !coldFound || !testFound {
+		panic("hand position not present in meta list")
 	}
 
-	if hotidx != c.hand_idx_hot || coldidx != c.hand_idx_cold || testidx != c.hand_idx_test {
-		fmt.Println(c.meta.Len(), hotidx, c.hand_idx_hot, coldidx, c.hand_idx_cold, testidx, c.hand_idx_test)
-		panic("index mismatch")
+	if nHot != c.count_hot || nCold != c.count_cold || nTest != c.count_test {
+		fmt.Println(nHot, c.count_hot, nCold, c.count_cold, nTest, c.count_test)
+		panic("page type counts mismatch")
 	}
 }
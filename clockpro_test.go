@@ -0,0 +1,227 @@
+package clockpro
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestRemoveToEmptyThenReinsert(t *testing.T) {
+	c := New(2)
+	c.Set("a", 1)
+	if !c.Remove("a") {
+		t.Fatalf("Remove(a) = false, want true")
+	}
+
+	// Regression: draining the cache to zero entries via Remove used to
+	// leave the hands pointing at the removed, detached list element,
+	// which made the next Set panic inside hand_cold.
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Set("d", 4)
+
+	if v := c.Get("d"); v != 4 {
+		t.Fatalf("Get(d) = %v, want 4", v)
+	}
+}
+
+func TestRemoveNotifiesOnEvictForGhostEntry(t *testing.T) {
+	c := New(1)
+	c.Set("a", 1)
+	c.Set("b", 2) // evicts "a" to the test (ghost) list
+
+	var got []string
+	c.OnEvict(func(key string, value interface{}) {
+		got = append(got, key)
+		if value != nil {
+			t.Fatalf("OnEvict(%s) value = %v, want nil for a ghost entry", key, value)
+		}
+	})
+
+	if !c.Remove("a") {
+		t.Fatalf("Remove(a) = false, want true")
+	}
+
+	if len(got) != 1 || got[0] != "a" {
+		t.Fatalf("OnEvict calls = %v, want [a]", got)
+	}
+}
+
+func TestPurgeNotifiesOnEvictForResidents(t *testing.T) {
+	c := New(2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	got := make(map[string]int)
+	c.OnEvict(func(key string, value interface{}) {
+		got[key] = value.(int)
+	})
+
+	c.Purge()
+
+	want := map[string]int{"a": 1, "b": 2}
+	if len(got) != len(want) || got["a"] != want["a"] || got["b"] != want["b"] {
+		t.Fatalf("OnEvict calls = %v, want %v", got, want)
+	}
+}
+
+func TestPurgeThenSetBehavesLikeFreshCache(t *testing.T) {
+	c := New(2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+
+	c.Purge()
+
+	if v := c.Get("a"); v != nil {
+		t.Fatalf("Get(a) after Purge = %v, want nil", v)
+	}
+
+	stats := c.Stats()
+	if stats.CountHot != 0 || stats.CountCold != 0 || stats.CountTest != 0 {
+		t.Fatalf("Stats after Purge = %+v, want all counts 0", stats)
+	}
+	if stats.MemCold != c.mem_max {
+		t.Fatalf("MemCold after Purge = %d, want %d (mem_max)", stats.MemCold, c.mem_max)
+	}
+
+	// A fresh cache accepts size entries before evicting any of them; Purge
+	// needs to have put the cache back in that same state.
+	c.Set("c", 3)
+	c.Set("d", 4)
+
+	if v := c.Get("c"); v != 3 {
+		t.Fatalf("Get(c) = %v, want 3", v)
+	}
+	if v := c.Get("d"); v != 4 {
+		t.Fatalf("Get(d) = %v, want 4", v)
+	}
+}
+
+func TestGhostCapBoundsTestListUnderCost(t *testing.T) {
+	// A realistic byte-scale budget (here stood in by 50 cost units) with a
+	// ghost cap much smaller than it: the test list must stay bounded by
+	// the ghost cap, not by mem_max, however many distinct keys cycle
+	// through the cache.
+	c := NewWithCost(50, func(string, interface{}) int64 { return 1 })
+	c.SetGhostCap(5)
+
+	for i := 0; i < 500; i++ {
+		c.Set(fmt.Sprintf("k%d", i), i)
+	}
+
+	if got := c.Stats().CountTest; got > 5 {
+		t.Fatalf("CountTest = %d, want <= 5 (SetGhostCap bound)", got)
+	}
+}
+
+func TestCostScaledMemColdAdaptation(t *testing.T) {
+	// mem_cold must move by a ghost entry's remembered cost when that entry
+	// ages out of the test list, not by a flat 1, or the cold-target
+	// adaptation is inert at byte scale.
+	costs := map[string]int64{"a": 5, "b": 1, "c": 1, "d": 4, "e": 5}
+	costFn := func(key string, _ interface{}) int64 { return costs[key] }
+
+	c := NewWithCost(10, costFn)
+	c.SetGhostCap(1)
+
+	var ghostCost int64 = -1
+	var memColdBeforeAging int64
+
+	c.OnEvict(func(key string, val interface{}) {
+		if val == nil {
+			// key is aging fully out of the test (ghost) list.
+			ghostCost = costs[key]
+			memColdBeforeAging = c.Stats().MemCold
+		}
+	})
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		c.Set(k, 1)
+	}
+
+	if ghostCost < 0 {
+		t.Fatalf("no ghost entry aged out of the test list; test didn't exercise hand_test")
+	}
+
+	if got := memColdBeforeAging - c.Stats().MemCold; got != ghostCost {
+		t.Fatalf("mem_cold dropped by %d aging out a ghost entry with cost %d, want equal", got, ghostCost)
+	}
+}
+
+func TestStatsHitsAndMisses(t *testing.T) {
+	c := New(10)
+
+	c.Set("a", 1)
+
+	c.Get("a")
+	c.Get("a")
+	c.Get("missing")
+
+	stats := c.Stats()
+	if stats.Hits != 2 {
+		t.Fatalf("Hits = %d, want 2", stats.Hits)
+	}
+	if stats.Misses != 1 {
+		t.Fatalf("Misses = %d, want 1", stats.Misses)
+	}
+	if stats.Insertions != 1 {
+		t.Fatalf("Insertions = %d, want 1", stats.Insertions)
+	}
+	if stats.CountCold != 1 {
+		t.Fatalf("CountCold = %d, want 1", stats.CountCold)
+	}
+}
+
+func TestStatsEvictionsAndTestHits(t *testing.T) {
+	c := New(1)
+
+	c.Set("a", 1)
+	c.Set("b", 2) // "a" has never been referenced, so it's demoted to the test list.
+
+	stats := c.Stats()
+	if stats.Evictions != 1 {
+		t.Fatalf("Evictions = %d, want 1", stats.Evictions)
+	}
+	if stats.CountTest != 1 {
+		t.Fatalf("CountTest = %d, want 1", stats.CountTest)
+	}
+
+	c.Set("a", 3) // re-inserting a ghost key is a test hit, promoted straight to hot.
+
+	stats = c.Stats()
+	if stats.TestHits != 1 {
+		t.Fatalf("TestHits = %d, want 1", stats.TestHits)
+	}
+}
+
+// TestHandBookkeepingStress drives a small cache through a long randomized
+// sequence of Get/Set/Remove calls with DEBUG enabled, so VerifyIdxs checks
+// the hand-pointer bookkeeping after every mutation. It's here to cover the
+// pointer-only meta_add/meta_del rewrite that replaced the old parallel
+// hand_idx_* counters: a panic from VerifyIdxs means the hands have drifted
+// out of sync with the meta list.
+func TestHandBookkeepingStress(t *testing.T) {
+	old := DEBUG
+	DEBUG = true
+	defer func() { DEBUG = old }()
+
+	c := New(8)
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+
+	for i := 0; i < 3000; i++ {
+		key := keys[r.Intn(len(keys))]
+		switch r.Intn(3) {
+		case 0:
+			c.Set(key, i)
+		case 1:
+			c.Get(key)
+		case 2:
+			c.Remove(key)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package clockpro implements a generic version of the CLOCK-Pro caching
+// algorithm, parameterized over key and value types. See the parent
+// package (github.com/dgryski/go-clockpro) for the interface{}-based
+// implementation and a description of the algorithm itself.
+package clockpro
+
+import "container/list"
+
+type pageType int
+
+const (
+	ptTest pageType = iota
+	ptCold
+	ptHot
+)
+
+type cacheEntry[V any] struct {
+	ref bool
+	val V
+}
+
+type metaEntry[K comparable] struct {
+	ptype pageType
+	key   K
+}
+
+// Cache is a generic CLOCK-Pro cache parameterized over the key and value
+// types. It mirrors the core hot/cold/test bookkeeping of the
+// interface{}-based Cache in the parent package, avoiding the
+// boxing/type-assertion cost of the interface{} API and letting Get report
+// a missing key via its second return value instead of an ambiguous nil.
+// It does not yet have equivalents of the parent package's OnEvict/Remove/
+// Purge, cost-based capacity, or Stats.
+type Cache[K comparable, V any] struct {
+	mem_max  int
+	mem_cold int
+	data     map[K]*cacheEntry[V]
+	meta     *list.List
+	metaKeys map[K]*list.Element
+
+	hand_pos_hot  *list.Element
+	hand_pos_cold *list.Element
+	hand_pos_test *list.Element
+
+	count_hot  int
+	count_cold int
+	count_test int
+}
+
+// New creates a new Cache with the given maximum number of entries.
+func New[K comparable, V any](size int) *Cache[K, V] {
+	return &Cache[K, V]{
+		mem_max:  size,
+		mem_cold: size,
+		data:     make(map[K]*cacheEntry[V]),
+		metaKeys: make(map[K]*list.Element),
+		meta:     list.New(),
+	}
+}
+
+// Get returns the value stored for key and true, or the zero value and
+// false if key is not present.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+
+	if v, ok := c.data[key]; ok && v != nil {
+		v.ref = true
+		return v.val, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Set stores value under key.
+func (c *Cache[K, V]) Set(key K, value V) {
+
+	if v, ok := c.data[key]; ok {
+		if v == nil {
+			if c.mem_cold < c.mem_max {
+				c.mem_cold++
+			}
+			c.meta_del(key)
+			c.data[key] = &cacheEntry[V]{ref: false, val: value}
+			c.count_test--
+			c.meta_add(ptHot, key)
+			c.count_hot++
+		} else {
+			v.val = value
+			v.ref = true
+		}
+	} else {
+		c.data[key] = &cacheEntry[V]{ref: false, val: value}
+		c.meta_add(ptCold, key)
+		c.count_cold++
+	}
+}
+
+func (c *Cache[K, V]) meta_add(ptype pageType, key K) {
+
+	c.evict()
+
+	mentry := &metaEntry[K]{
+		ptype: ptype,
+		key:   key,
+	}
+
+	if c.hand_pos_hot == nil {
+		// first element
+		elt := c.meta.PushFront(mentry)
+		c.metaKeys[key] = elt
+		c.hand_pos_hot = elt
+		c.hand_pos_cold = elt
+		c.hand_pos_test = elt
+		return
+	}
+
+	// New pages are always threaded in just behind the hot hand. hot and
+	// test keep pointing at the same element they did before the insert
+	// (container/list pointers are unaffected by inserting elsewhere), but
+	// if the cold hand was sitting on the same element as the hot hand, it
+	// needs to pick up the freshly-inserted page instead of being left
+	// behind it.
+	coldAtHot := c.hand_pos_cold == c.hand_pos_hot
+
+	elt := c.meta.InsertBefore(mentry, c.hand_pos_hot)
+	c.metaKeys[key] = elt
+
+	if coldAtHot {
+		c.hand_pos_cold = elt
+	}
+}
+
+func (c *Cache[K, V]) meta_del(key K) {
+
+	elt, ok := c.metaKeys[key]
+
+	if !ok {
+		panic("key not present in remove!")
+	}
+
+	delete(c.metaKeys, key)
+
+	if elt == c.hand_pos_hot {
+		c.hand_pos_hot = c.hand_pos_hot.Prev()
+		if c.hand_pos_hot == nil {
+			c.hand_pos_hot = c.meta.Back()
+		}
+	}
+
+	if elt == c.hand_pos_cold {
+		c.hand_pos_cold = c.hand_pos_cold.Prev()
+		if c.hand_pos_cold == nil {
+			c.hand_pos_cold = c.meta.Back()
+		}
+	}
+
+	if elt == c.hand_pos_test {
+		c.hand_pos_test = c.hand_pos_test.Prev()
+		if c.hand_pos_test == nil {
+			c.hand_pos_test = c.meta.Back()
+		}
+	}
+
+	c.meta.Remove(elt)
+
+	if c.meta.Len() == 0 {
+		// No elements left for any hand to retreat to: reset to the same
+		// zero state New starts in, so the next meta_add takes the
+		// "first element" path instead of dereferencing a hand pointing
+		// at the element we just removed.
+		c.hand_pos_hot = nil
+		c.hand_pos_cold = nil
+		c.hand_pos_test = nil
+	}
+}
+
+func (c *Cache[K, V]) evict() {
+
+	for c.mem_max <= c.count_hot+c.count_cold {
+		c.hand_cold()
+	}
+}
+
+func (c *Cache[K, V]) hand_cold() {
+
+	meta := c.hand_pos_cold.Value.(*metaEntry[K])
+
+	if meta.ptype == ptCold {
+		data := c.data[meta.key]
+
+		if data.ref {
+			meta.ptype = ptHot
+			data.ref = false
+			c.count_cold--
+			c.count_hot++
+		} else {
+			meta.ptype = ptTest
+			c.data[meta.key] = nil
+			c.count_cold--
+			c.count_test++
+			for c.mem_max < c.count_test {
+				c.hand_test()
+			}
+		}
+	}
+
+	c.hand_pos_cold = c.hand_pos_cold.Next()
+	if c.hand_pos_cold == nil {
+		c.hand_pos_cold = c.meta.Front()
+	}
+
+	for c.mem_max-c.mem_cold < c.count_hot {
+		c.hand_hot()
+	}
+}
+
+func (c *Cache[K, V]) hand_hot() {
+
+	if c.hand_pos_hot == c.hand_pos_test {
+		c.hand_test()
+	}
+
+	meta := c.hand_pos_hot.Value.(*metaEntry[K])
+
+	if meta.ptype == ptHot {
+		data := c.data[meta.key]
+
+		if data.ref {
+			data.ref = false
+		} else {
+			meta.ptype = ptCold
+			c.count_hot--
+			c.count_cold++
+		}
+	}
+
+	c.hand_pos_hot = c.hand_pos_hot.Next()
+	if c.hand_pos_hot == nil {
+		c.hand_pos_hot = c.meta.Front()
+	}
+}
+
+func (c *Cache[K, V]) hand_test() {
+
+	if c.hand_pos_test == c.hand_pos_cold {
+		c.hand_cold()
+	}
+
+	meta := c.hand_pos_test.Value.(*metaEntry[K])
+
+	if meta.ptype == ptTest {
+
+		delete(c.data, meta.key)
+
+		// meta_del retreats hand_pos_test to the element preceding this
+		// one (wrapping to Back() if it was Front()) since it still
+		// equals c.hand_pos_test here.
+		c.meta_del(meta.key)
+
+		c.count_test--
+		if c.mem_cold > 1 {
+			c.mem_cold--
+		}
+	}
+
+	c.hand_pos_test = c.hand_pos_test.Next()
+	if c.hand_pos_test == nil {
+		c.hand_pos_test = c.meta.Front()
+	}
+}
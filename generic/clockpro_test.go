@@ -0,0 +1,69 @@
+package clockpro
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestGetSetMiss(t *testing.T) {
+	c := New[string, int](2)
+
+	c.Set("a", 1)
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = (%v, %v), want (1, true)", v, ok)
+	}
+
+	if v, ok := c.Get("missing"); ok || v != 0 {
+		t.Fatalf("Get(missing) = (%v, %v), want (0, false)", v, ok)
+	}
+}
+
+func TestReferencedEntrySurvivesEviction(t *testing.T) {
+	c := New[string, int](2)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	// Keep "a" referenced so "b" is the one reclaimed under pressure.
+	c.Get("a")
+
+	c.Set("c", 3)
+	c.Set("d", 4)
+
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(a) = (_, false), want a to still be resident")
+	}
+}
+
+func TestIntKeys(t *testing.T) {
+	c := New[int, string](8)
+
+	for i := 0; i < 100; i++ {
+		c.Set(i, fmt.Sprintf("v%d", i))
+	}
+}
+
+// TestHandBookkeepingStress drives a small cache through a long randomized
+// sequence of Get/Set calls, covering the pointer-only meta_add/meta_del
+// that replaced this package's old hand_idx_* counters: any corruption in
+// the hand bookkeeping would surface as a nil-pointer panic or an infinite
+// loop here.
+func TestHandBookkeepingStress(t *testing.T) {
+	c := New[string, int](8)
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+
+	for i := 0; i < 3000; i++ {
+		key := keys[r.Intn(len(keys))]
+		if r.Intn(2) == 0 {
+			c.Set(key, i)
+		} else {
+			c.Get(key)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package clockpro
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ShardedCache is a concurrency-safe CLOCK-Pro cache made up of a number of
+// independent Cache shards, each guarded by its own mutex. Keys are
+// distributed across shards by hashing with fnv-1a, which spreads
+// contention across the shards under concurrent access instead of
+// serializing all callers behind a single lock.
+type ShardedCache struct {
+	shards []shard
+	mask   uint32
+}
+
+type shard struct {
+	mu sync.Mutex
+	c  *Cache
+}
+
+// NewSharded creates a ShardedCache with the given total size, split evenly
+// across shards independent Cache instances. shards is rounded up to the
+// next power of two so the shard for a key can be picked with a mask
+// instead of a modulo. Each shard holds size/n entries (at least one),
+// where n is the rounded shard count, so the total capacity is below size
+// when size is not a multiple of n and above it when size is less than n.
+func NewSharded(size, shards int) *ShardedCache {
+	if shards < 1 {
+		shards = 1
+	}
+
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	sc := &ShardedCache{
+		shards: make([]shard, n),
+		mask:   uint32(n - 1),
+	}
+
+	perShard := size / n
+	if perShard < 1 {
+		perShard = 1
+	}
+
+	for i := range sc.shards {
+		sc.shards[i].c = New(perShard)
+	}
+
+	return sc
+}
+
+func (sc *ShardedCache) shardFor(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &sc.shards[h.Sum32()&sc.mask]
+}
+
+// Get returns the value stored for key, or nil if it is not present.
+func (sc *ShardedCache) Get(key string) interface{} {
+	s := sc.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.Get(key)
+}
+
+// Set stores value under key.
+func (sc *ShardedCache) Set(key string, value interface{}) {
+	s := sc.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.Set(key, value)
+}
@@ -0,0 +1,51 @@
+package clockpro
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestShardedCacheGetSet(t *testing.T) {
+	sc := NewSharded(64, 4)
+
+	for i := 0; i < 50; i++ {
+		sc.Set(fmt.Sprintf("k%d", i), i)
+	}
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if v := sc.Get(key); v != i {
+			t.Fatalf("Get(%s) = %v, want %d", key, v, i)
+		}
+	}
+
+	if v := sc.Get("missing"); v != nil {
+		t.Fatalf("Get(missing) = %v, want nil", v)
+	}
+}
+
+func TestShardedCacheConcurrent(t *testing.T) {
+	sc := NewSharded(256, 8)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("g%d-k%d", g, i)
+				sc.Set(key, i)
+				sc.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestNewShardedRoundsShardsUpToPowerOfTwo(t *testing.T) {
+	sc := NewSharded(100, 3)
+	if len(sc.shards) != 4 {
+		t.Fatalf("len(shards) = %d, want 4 (3 rounded up to a power of two)", len(sc.shards))
+	}
+}